@@ -1,12 +1,18 @@
 package main
 
 import (
-	"bytes"
+	"container/list"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/bigquery"
+	storage "cloud.google.com/go/bigquery/storage/apiv1"
+	"github.com/googleapis/gax-go/v2"
 	"github.com/googleapis/google-cloud-go-testing/bigquery/bqiface"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v2"
@@ -18,48 +24,324 @@ import (
 
 const (
 	MaxBQClientReuse = 5
+
+	// MaxCachedBQClients bounds how many distinct credentials can have a
+	// live client cached at once. Once exceeded, the least recently used
+	// entry is evicted and closed.
+	MaxCachedBQClients = 10
+
+	// BQClientTTL is how long a cached client may be reused before it is
+	// rebuilt, regardless of timesUsed, so long-lived processes still pick
+	// up refreshed tokens.
+	BQClientTTL = 30 * time.Minute
 )
 
+// cachedBQClient is a single entry in DefaultBQClientFactory's LRU cache.
+type cachedBQClient struct {
+	key       string
+	client    bqiface.Client
+	timesUsed int
+	expiresAt time.Time
+
+	// refs counts handles handed out by acquire that have not yet been
+	// released. evicted entries whose refs has not yet dropped to zero keep
+	// their underlying client open until the last holder releases it.
+	refs    int
+	evicted bool
+}
+
+// canReuse reports whether entry can be handed out again, as of now, without
+// rebuilding its underlying client.
+func canReuse(entry *cachedBQClient, now time.Time) bool {
+	return entry.timesUsed < MaxBQClientReuse && now.Before(entry.expiresAt)
+}
+
+// ClientConfig customizes how DefaultBQClientFactory builds BigQuery
+// clients. The zero value builds a client against the standard BigQuery
+// endpoint with no quota project override, the default gax retry policy, and
+// the client library's default user agent.
+type ClientConfig struct {
+	// Endpoint overrides the default BigQuery API endpoint, e.g. to point at
+	// a bigquery-emulator instance or a private-service-connect endpoint
+	// for integration tests.
+	Endpoint string
+
+	// QuotaProject attributes API quota to a billing project distinct from
+	// the project embedded in the credential.
+	QuotaProject string
+
+	// UserAgent is passed to option.WithUserAgent for every client the
+	// factory builds. It replaces the client library's default user agent
+	// string; it is not appended to it.
+	UserAgent string
+
+	// RetryPolicy overrides the default gax retry behaviour used by the
+	// storage_read transport's RPCs.
+	RetryPolicy gax.CallOption
+}
+
+// DefaultBQClientFactory caches BQ clients keyed by the SHA-256 of the
+// credential JSON (or, for Application Default Credentials, by project ID),
+// so tasks that run under many different service accounts in the same
+// process can reuse a client per credential instead of thrashing a single
+// cached client.
 type DefaultBQClientFactory struct {
-	cachedClient bqiface.Client
-	cachedCred   *google.Credentials
-	timesUsed    int
-	mu           sync.Mutex
+	Config ClientConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front = most recently used
 }
 
+// New returns a bqiface.Client for svcAccount, reusing a cached client when
+// one is still valid. The returned client is reference-counted: callers must
+// Close it when they're done with it. Doing so never closes the underlying
+// connection out from under a concurrent caller — it only does once the
+// entry has been evicted from the cache and every other holder has also
+// released it.
 func (fac *DefaultBQClientFactory) New(ctx context.Context, svcAccount string) (bqiface.Client, error) {
 	fac.mu.Lock()
 	defer fac.mu.Unlock()
 
-	cred, err := google.CredentialsFromJSON(ctx, []byte(svcAccount),
-		bigquery.Scope, storageV1.CloudPlatformScope, drive.DriveScope)
+	if fac.entries == nil {
+		fac.entries = make(map[string]*list.Element)
+		fac.lru = list.New()
+	}
+
+	cred, key, err := resolveCredentials(ctx, svcAccount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secret: %w", err)
+		return nil, err
 	}
 
-	// check if cached client can be reused
-	if fac.cachedCred != nil && fac.cachedClient != nil && fac.timesUsed == MaxBQClientReuse &&
-		bytes.Equal(cred.JSON, fac.cachedCred.JSON) {
-		fac.timesUsed++
-		return fac.cachedClient, nil
+	if elem, ok := fac.entries[key]; ok {
+		entry := elem.Value.(*cachedBQClient)
+		if canReuse(entry, time.Now()) {
+			entry.timesUsed++
+			fac.lru.MoveToFront(elem)
+			return fac.acquire(entry), nil
+		}
+		fac.evict(elem)
 	}
 
-	client, err := bigquery.NewClient(ctx, cred.ProjectID, option.WithCredentials(cred))
+	client, err := bigquery.NewClient(ctx, cred.ProjectID, fac.clientOptions(cred)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create BQ client: %w", err)
 	}
 
-	fac.cachedCred = cred
-	fac.cachedClient = bqiface.AdaptClient(client)
-	fac.timesUsed = 1
-	return fac.cachedClient, nil
+	entry := &cachedBQClient{
+		key:       key,
+		client:    bqiface.AdaptClient(client),
+		timesUsed: 1,
+		expiresAt: time.Now().Add(BQClientTTL),
+	}
+	fac.entries[key] = fac.lru.PushFront(entry)
+
+	for fac.lru.Len() > MaxCachedBQClients {
+		fac.evict(fac.lru.Back())
+	}
+
+	return fac.acquire(entry), nil
+}
+
+// acquire hands out a reference-counted handle to entry's client. Callers
+// must hold fac.mu.
+func (fac *DefaultBQClientFactory) acquire(entry *cachedBQClient) bqiface.Client {
+	entry.refs++
+	return &refCountedBQClient{Client: entry.client, fac: fac, entry: entry}
+}
+
+// release drops a reference to entry and, if entry has since been evicted
+// and no other caller still holds it, closes the underlying client.
+func (fac *DefaultBQClientFactory) release(entry *cachedBQClient) error {
+	fac.mu.Lock()
+	defer fac.mu.Unlock()
+
+	entry.refs--
+	if entry.refs > 0 || !entry.evicted {
+		return nil
+	}
+	return closeClient(entry.client)
+}
+
+// refCountedBQClient wraps a cached bqiface.Client so that Close releases
+// only this caller's reference instead of closing the shared connection.
+type refCountedBQClient struct {
+	bqiface.Client
+	fac   *DefaultBQClientFactory
+	entry *cachedBQClient
+}
+
+func (r *refCountedBQClient) Close() error {
+	return r.fac.release(r.entry)
+}
+
+// NewStorageReadClient builds a BigQueryReadClient for svcAccount, resolving
+// credentials through the same path as New so the read and jobs.query
+// clients for a credential always agree on identity. It does not use New's
+// client cache: every call resolves the credential again and returns a new
+// client.
+func (fac *DefaultBQClientFactory) NewStorageReadClient(ctx context.Context, svcAccount string) (*storage.BigQueryReadClient, error) {
+	fac.mu.Lock()
+	cred, _, err := resolveCredentials(ctx, svcAccount)
+	fac.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	readClient, err := storage.NewBigQueryReadClient(ctx, fac.clientOptions(cred)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BQ storage read client: %w", err)
+	}
+	return readClient, nil
+}
+
+// clientOptions builds the option.ClientOptions shared by every BigQuery
+// client this factory constructs, applying the credential plus any
+// overrides set on fac.Config.
+func (fac *DefaultBQClientFactory) clientOptions(cred *google.Credentials) []option.ClientOption {
+	opts := []option.ClientOption{option.WithCredentials(cred)}
+	if fac.Config.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(fac.Config.Endpoint))
+	}
+	if fac.Config.QuotaProject != "" {
+		opts = append(opts, option.WithQuotaProject(fac.Config.QuotaProject))
+	}
+	if fac.Config.UserAgent != "" {
+		opts = append(opts, option.WithUserAgent(fac.Config.UserAgent))
+	}
+	return opts
+}
+
+// NewDriveService builds a Drive v2 client for svcAccount, resolving
+// credentials through the same path as New, so federated Drive/Sheets
+// external tables resolve under the same identity as the query itself.
+func (fac *DefaultBQClientFactory) NewDriveService(ctx context.Context, svcAccount string) (*drive.Service, error) {
+	fac.mu.Lock()
+	cred, _, err := resolveCredentials(ctx, svcAccount)
+	fac.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Config.Endpoint names a BigQuery endpoint, so the Drive client keeps
+	// Drive's default endpoint.
+	driveFac := &DefaultBQClientFactory{Config: fac.Config}
+	driveFac.Config.Endpoint = ""
+	svc, err := drive.NewService(ctx, driveFac.clientOptions(cred)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Drive client: %w", err)
+	}
+	return svc, nil
+}
+
+// evict removes elem from the cache and, if no caller currently holds a
+// reference to its client, closes it. If callers still hold it, closing is
+// deferred to release once the last of them lets go. Callers must hold
+// fac.mu.
+func (fac *DefaultBQClientFactory) evict(elem *list.Element) {
+	if elem == nil {
+		return
+	}
+	entry := elem.Value.(*cachedBQClient)
+	fac.lru.Remove(elem)
+	delete(fac.entries, entry.key)
+	entry.evicted = true
+	if entry.refs == 0 {
+		_ = closeClient(entry.client)
+	}
+}
+
+func closeClient(client bqiface.Client) error {
+	if closer, ok := client.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func credentialCacheKey(credJSON []byte) string {
+	sum := sha256.Sum256(credJSON)
+	return hex.EncodeToString(sum[:])
+}
+
+// bqScopes are requested for every credential, whether inline or ADC, so the
+// resulting client can also back upstream dependency and Drive federated
+// source resolution.
+var bqScopes = []string{bigquery.Scope, storageV1.CloudPlatformScope, drive.DriveScope}
+
+// resolveCredentials reads an inline service-account JSON blob, or, when
+// svcAccount is empty, falls back to Application Default Credentials
+// (GOOGLE_APPLICATION_CREDENTIALS, GKE Workload Identity, or the GCE/GKE
+// metadata server) the same way the standard bigquery client bootstrap does.
+// It also returns the cache key to use for the resolved credential so ADC
+// entries never collide with keyed service accounts.
+func resolveCredentials(ctx context.Context, svcAccount string) (*google.Credentials, string, error) {
+	if svcAccount == "" {
+		cred, err := google.FindDefaultCredentials(ctx, bqScopes...)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to find default credentials: %w", err)
+		}
+		return cred, "adc:" + cred.ProjectID, nil
+	}
+
+	cred, err := google.CredentialsFromJSON(ctx, []byte(svcAccount), bqScopes...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	return cred, credentialCacheKey(cred.JSON), nil
 }
 
+// UpstreamExtractorTransport selects which BigQuery API DefaultUpstreamExtractorFactory
+// uses to resolve a query's upstream dependencies.
+type UpstreamExtractorTransport string
+
+const (
+	// UpstreamExtractorTransportJobsQuery resolves dependencies with normal
+	// jobs.query calls. This is the default and bills scanned bytes against
+	// the project's query slots like any other query.
+	UpstreamExtractorTransportJobsQuery UpstreamExtractorTransport = "jobs_query"
+
+	// UpstreamExtractorTransportStorageRead resolves dependencies through
+	// the BigQuery Storage Read API, which is cheaper for large
+	// INFORMATION_SCHEMA scans and doesn't compete with normal query slots.
+	UpstreamExtractorTransportStorageRead UpstreamExtractorTransport = "storage_read"
+)
+
 type DefaultUpstreamExtractorFactory struct {
+	// BQClientFactory builds the BigQueryReadClient for the storage_read
+	// transport from the same credential as the requesting client. The read
+	// client is built anew on each call; it is not taken from or added to
+	// the factory's client cache.
+	BQClientFactory *DefaultBQClientFactory
 }
 
-func (d *DefaultUpstreamExtractorFactory) New(client bqiface.Client) (UpstreamExtractor, error) {
-	extractor, err := upstream.NewExtractor(client)
+func (d *DefaultUpstreamExtractorFactory) New(ctx context.Context, client bqiface.Client, svcAccount string, transport UpstreamExtractorTransport) (UpstreamExtractor, error) {
+	if transport == UpstreamExtractorTransportStorageRead {
+		readClient, err := d.BQClientFactory.NewStorageReadClient(ctx, svcAccount)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing storage read client: %w", err)
+		}
+
+		extractor, err := upstream.NewStorageReadExtractor(client, readClient, d.BQClientFactory.Config.RetryPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing storage read extractor: %w", err)
+		}
+
+		return extractor, nil
+	}
+
+	// Drive/Sheets lineage is a best-effort extra on top of the standard
+	// INFORMATION_SCHEMA resolution: most tasks never touch Drive at all, so
+	// a Drive client construction failure (API disabled, missing scope, org
+	// policy, transient auth hiccup) must not fail the whole extractor.
+	// upstream.NewExtractor treats a nil driveService as "skip Drive
+	// resolution".
+	driveSvc, err := d.BQClientFactory.NewDriveService(ctx, svcAccount)
+	if err != nil {
+		log.Printf("bq2bq: failed to initialize drive client, drive/sheets lineage resolution disabled: %v", err)
+		driveSvc = nil
+	}
+
+	extractor, err := upstream.NewExtractor(client, driveSvc)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing extractor: %w", err)
 	}
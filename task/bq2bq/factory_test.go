@@ -0,0 +1,205 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googleapis/google-cloud-go-testing/bigquery/bqiface"
+)
+
+func TestCanReuse(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name  string
+		entry *cachedBQClient
+		want  bool
+	}{
+		{
+			name:  "fresh entry under reuse limit",
+			entry: &cachedBQClient{timesUsed: 0, expiresAt: now.Add(time.Minute)},
+			want:  true,
+		},
+		{
+			name:  "at reuse limit",
+			entry: &cachedBQClient{timesUsed: MaxBQClientReuse, expiresAt: now.Add(time.Minute)},
+			want:  false,
+		},
+		{
+			name:  "one below reuse limit",
+			entry: &cachedBQClient{timesUsed: MaxBQClientReuse - 1, expiresAt: now.Add(time.Minute)},
+			want:  true,
+		},
+		{
+			name:  "expired",
+			entry: &cachedBQClient{timesUsed: 0, expiresAt: now.Add(-time.Second)},
+			want:  false,
+		},
+		{
+			name:  "expired and over reuse limit",
+			entry: &cachedBQClient{timesUsed: MaxBQClientReuse, expiresAt: now.Add(-time.Second)},
+			want:  false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := canReuse(tc.entry, now); got != tc.want {
+				t.Errorf("canReuse() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCredentialCacheKey(t *testing.T) {
+	a := credentialCacheKey([]byte(`{"client_email":"a@example.com"}`))
+	b := credentialCacheKey([]byte(`{"client_email":"b@example.com"}`))
+	aAgain := credentialCacheKey([]byte(`{"client_email":"a@example.com"}`))
+
+	if a == b {
+		t.Errorf("credentialCacheKey produced the same key for different credential JSON")
+	}
+	if a != aAgain {
+		t.Errorf("credentialCacheKey is not deterministic for the same input")
+	}
+}
+
+func TestResolveCredentialsServiceAccountKeyMatchesCredentialCacheKey(t *testing.T) {
+	svcAccount := `{
+		"type": "authorized_user",
+		"client_id": "test-client-id",
+		"client_secret": "test-client-secret",
+		"refresh_token": "test-refresh-token"
+	}`
+
+	cred, key, err := resolveCredentials(context.Background(), svcAccount)
+	if err != nil {
+		t.Fatalf("resolveCredentials() error = %v", err)
+	}
+
+	want := credentialCacheKey(cred.JSON)
+	if key != want {
+		t.Errorf("resolveCredentials() key = %q, want %q", key, want)
+	}
+}
+
+func TestResolveCredentialsADCFailureIsWrapped(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+	_, _, err := resolveCredentials(context.Background(), "")
+	if err == nil {
+		t.Skip("environment has usable Application Default Credentials; nothing to assert")
+	}
+	if !strings.HasPrefix(err.Error(), "failed to find default credentials: ") || errors.Unwrap(err) == nil {
+		t.Errorf("resolveCredentials() error = %v, want it wrapped with %q", err, "failed to find default credentials")
+	}
+}
+
+// closeCountingClient is a bqiface.Client whose Close only counts its calls,
+// so the eviction tests can observe when the cache closes a client.
+type closeCountingClient struct {
+	bqiface.Client
+	closes int
+}
+
+func (c *closeCountingClient) Close() error {
+	c.closes++
+	return nil
+}
+
+// evictionFactory builds a DefaultBQClientFactory with its cache
+// pre-initialized, so tests can drive evict/acquire/release directly without
+// going through New (which requires a live credential and bigquery.NewClient
+// call).
+func evictionFactory() *DefaultBQClientFactory {
+	return &DefaultBQClientFactory{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (fac *DefaultBQClientFactory) push(key string) *cachedBQClient {
+	entry := &cachedBQClient{key: key, expiresAt: time.Now().Add(time.Hour)}
+	fac.entries[key] = fac.lru.PushFront(entry)
+	return entry
+}
+
+func TestEvictOrdersLeastRecentlyUsed(t *testing.T) {
+	fac := evictionFactory()
+	fac.push("a")
+	fac.push("b")
+	fac.push("c")
+
+	fac.evict(fac.lru.Back()) // evicts "a", the least recently used
+
+	if _, ok := fac.entries["a"]; ok {
+		t.Errorf("entry %q still present after eviction", "a")
+	}
+	if fac.lru.Len() != 2 {
+		t.Errorf("lru.Len() = %d, want 2", fac.lru.Len())
+	}
+}
+
+func TestEvictDefersCloseUntilLastReferenceReleased(t *testing.T) {
+	fac := evictionFactory()
+	entry := fac.push("a")
+	client := &closeCountingClient{}
+	entry.client = client
+
+	h1 := fac.acquire(entry)
+	h2 := fac.acquire(entry)
+
+	fac.evict(fac.lru.Front())
+	if _, ok := fac.entries["a"]; ok {
+		t.Fatalf("entry still present in the cache after eviction")
+	}
+	if entry.refs != 2 {
+		t.Fatalf("entry.refs = %d, want 2 (two outstanding handles)", entry.refs)
+	}
+	if client.closes != 0 {
+		t.Errorf("client closed %d times on eviction with outstanding handles, want 0", client.closes)
+	}
+
+	if err := h1.Close(); err != nil {
+		t.Fatalf("h1.Close() error = %v", err)
+	}
+	if entry.refs != 1 {
+		t.Errorf("entry.refs = %d after one release, want 1", entry.refs)
+	}
+	if client.closes != 0 {
+		t.Errorf("client closed %d times after one release, want 0", client.closes)
+	}
+
+	if err := h2.Close(); err != nil {
+		t.Fatalf("h2.Close() error = %v", err)
+	}
+	if entry.refs != 0 {
+		t.Errorf("entry.refs = %d after both released, want 0", entry.refs)
+	}
+	if client.closes != 1 {
+		t.Errorf("client closed %d times after both released, want 1", client.closes)
+	}
+}
+
+func TestEvictClosesImmediatelyWhenUnreferenced(t *testing.T) {
+	fac := evictionFactory()
+	entry := fac.push("a")
+	client := &closeCountingClient{}
+	entry.client = client
+
+	fac.evict(fac.lru.Front())
+
+	if !entry.evicted {
+		t.Errorf("entry.evicted = false, want true")
+	}
+	if entry.refs != 0 {
+		t.Errorf("entry.refs = %d, want 0", entry.refs)
+	}
+	if client.closes != 1 {
+		t.Errorf("client closed %d times, want 1", client.closes)
+	}
+}
@@ -0,0 +1,96 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/googleapis/google-cloud-go-testing/bigquery/bqiface"
+)
+
+func TestResourceFromRow(t *testing.T) {
+	cases := []struct {
+		name string
+		row  []bqiface.Value
+		want Resource
+	}{
+		{
+			name: "full row",
+			row:  []bqiface.Value{"proj", "ds", "tbl"},
+			want: Resource{Project: "proj", Dataset: "ds", Name: "tbl"},
+		},
+		{
+			name: "empty row",
+			row:  nil,
+			want: Resource{},
+		},
+		{
+			name: "short row",
+			row:  []bqiface.Value{"proj"},
+			want: Resource{Project: "proj"},
+		},
+		{
+			name: "non-string values",
+			row:  []bqiface.Value{1, 2, 3},
+			want: Resource{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := resourceFromRow(tc.row); got != tc.want {
+				t.Errorf("resourceFromRow(%v) = %+v, want %+v", tc.row, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDriveFileID(t *testing.T) {
+	cases := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{
+			name: "drive scheme",
+			uri:  "drive://abc123",
+			want: "abc123",
+		},
+		{
+			name: "drive scheme with trailing query",
+			uri:  "drive://abc123?rev=2",
+			want: "abc123",
+		},
+		{
+			name: "sheets url with edit path and fragment",
+			uri:  "https://docs.google.com/spreadsheets/d/abc123/edit#gid=0",
+			want: "abc123",
+		},
+		{
+			name: "sheets url with query string and no following slash",
+			uri:  "https://docs.google.com/spreadsheets/d/abc123?usp=sharing",
+			want: "abc123",
+		},
+		{
+			name: "sheets url with bare id",
+			uri:  "https://docs.google.com/spreadsheets/d/abc123",
+			want: "abc123",
+		},
+		{
+			name: "unrelated uri",
+			uri:  "gs://bucket/file.csv",
+			want: "",
+		},
+		{
+			name: "empty uri",
+			uri:  "",
+			want: "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := driveFileID(tc.uri); got != tc.want {
+				t.Errorf("driveFileID(%q) = %q, want %q", tc.uri, got, tc.want)
+			}
+		})
+	}
+}
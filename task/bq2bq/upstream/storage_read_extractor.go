@@ -0,0 +1,142 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	storage "cloud.google.com/go/bigquery/storage/apiv1"
+	storagepb "cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/googleapis/google-cloud-go-testing/bigquery/bqiface"
+	"github.com/linkedin/goavro/v2"
+)
+
+// upstreamIdentityColumns are the only INFORMATION_SCHEMA columns a
+// dependency scan needs to identify an upstream resource. Restricting the
+// Storage Read session to them is what keeps this transport cheaper than the
+// jobs.query path, which would otherwise scan every column in the table.
+var upstreamIdentityColumns = []string{"table_catalog", "table_schema", "table_name"}
+
+// StorageReadExtractor resolves upstream resources the same way Extractor
+// does, but reads INFORMATION_SCHEMA through the BigQuery Storage Read API
+// instead of jobs.query. This keeps heavy dependency-graph resolution off
+// the project's query slots and off its scanned-bytes billing, which matters
+// for tables as large as INFORMATION_SCHEMA.JOBS or .VIEWS on big projects.
+type StorageReadExtractor struct {
+	client     bqiface.Client
+	readClient *storage.BigQueryReadClient
+	retryOpts  []gax.CallOption
+}
+
+// NewStorageReadExtractor builds a StorageReadExtractor. readClient must
+// share credentials with client so both see the same project's data. retry
+// may be nil, in which case readClient's default retry policy applies.
+func NewStorageReadExtractor(client bqiface.Client, readClient *storage.BigQueryReadClient, retry gax.CallOption) (*StorageReadExtractor, error) {
+	if client == nil {
+		return nil, fmt.Errorf("bigquery client is required")
+	}
+	if readClient == nil {
+		return nil, fmt.Errorf("bigquery storage read client is required")
+	}
+
+	var retryOpts []gax.CallOption
+	if retry != nil {
+		retryOpts = []gax.CallOption{retry}
+	}
+	return &StorageReadExtractor{client: client, readClient: readClient, retryOpts: retryOpts}, nil
+}
+
+// Extract reads table through a Storage Read API session instead of a
+// jobs.query call and returns one Resource per row. Unlike Extractor.Extract,
+// its argument is not a SQL query: it is used as the session's table path
+// (projects/<project>/datasets/<dataset>/tables/<table>). The session is
+// restricted to upstreamIdentityColumns so it doesn't stream the entire
+// table.
+func (e *StorageReadExtractor) Extract(ctx context.Context, table string) ([]Resource, error) {
+	session, err := e.readClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+		Parent: fmt.Sprintf("projects/%s", e.client.Project()),
+		ReadSession: &storagepb.ReadSession{
+			Table:      table,
+			DataFormat: storagepb.DataFormat_AVRO,
+			ReadOptions: &storagepb.TableReadOptions{
+				SelectedFields: upstreamIdentityColumns,
+			},
+		},
+		MaxStreamCount: 1,
+	}, e.retryOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage read session: %w", err)
+	}
+	if len(session.GetStreams()) == 0 {
+		return nil, nil
+	}
+
+	stream, err := e.readClient.ReadRows(ctx, &storagepb.ReadRowsRequest{
+		ReadStream: session.GetStreams()[0].GetName(),
+	}, e.retryOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage read stream: %w", err)
+	}
+
+	codec, err := goavro.NewCodec(session.GetAvroSchema().GetSchema())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse storage read avro schema: %w", err)
+	}
+
+	var resources []Resource
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rows: %w", err)
+		}
+
+		rowResources, err := resourcesFromAvroRows(codec, resp.GetAvroRows())
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, rowResources...)
+	}
+
+	return resources, nil
+}
+
+// resourcesFromAvroRows decodes the raw Avro batch returned by the Storage
+// Read API into Resources using codec, which must match the schema the
+// session was opened with.
+func resourcesFromAvroRows(codec *goavro.Codec, rows *storagepb.AvroRows) ([]Resource, error) {
+	if rows == nil {
+		return nil, nil
+	}
+
+	var resources []Resource
+	buf := rows.GetSerializedBinaryRows()
+	for len(buf) > 0 {
+		native, rest, err := codec.NativeFromBinary(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode avro row: %w", err)
+		}
+		buf = rest
+
+		fields, ok := native.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected avro row shape %T", native)
+		}
+
+		resources = append(resources, Resource{
+			Project: avroStringField(fields, "table_catalog"),
+			Dataset: avroStringField(fields, "table_schema"),
+			Name:    avroStringField(fields, "table_name"),
+		})
+	}
+
+	return resources, nil
+}
+
+func avroStringField(fields map[string]interface{}, name string) string {
+	v, _ := fields[name].(string)
+	return v
+}
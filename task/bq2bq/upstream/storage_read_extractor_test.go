@@ -0,0 +1,81 @@
+package upstream
+
+import (
+	"reflect"
+	"testing"
+
+	storagepb "cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"github.com/linkedin/goavro/v2"
+)
+
+const identityColumnsSchema = `{
+	"type": "record",
+	"name": "Row",
+	"fields": [
+		{"name": "table_catalog", "type": "string"},
+		{"name": "table_schema", "type": "string"},
+		{"name": "table_name", "type": "string"}
+	]
+}`
+
+func TestResourcesFromAvroRows(t *testing.T) {
+	codec, err := goavro.NewCodec(identityColumnsSchema)
+	if err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+
+	want := []Resource{
+		{Project: "proj-a", Dataset: "ds-a", Name: "table-a"},
+		{Project: "proj-b", Dataset: "ds-b", Name: "table-b"},
+	}
+
+	var buf []byte
+	for _, r := range want {
+		encoded, err := codec.BinaryFromNative(nil, map[string]interface{}{
+			"table_catalog": r.Project,
+			"table_schema":  r.Dataset,
+			"table_name":    r.Name,
+		})
+		if err != nil {
+			t.Fatalf("BinaryFromNative() error = %v", err)
+		}
+		buf = append(buf, encoded...)
+	}
+
+	got, err := resourcesFromAvroRows(codec, &storagepb.AvroRows{SerializedBinaryRows: buf})
+	if err != nil {
+		t.Fatalf("resourcesFromAvroRows() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resourcesFromAvroRows() = %+v, want %+v", got, want)
+	}
+}
+
+func TestResourcesFromAvroRowsNilRows(t *testing.T) {
+	codec, err := goavro.NewCodec(identityColumnsSchema)
+	if err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+
+	got, err := resourcesFromAvroRows(codec, nil)
+	if err != nil {
+		t.Fatalf("resourcesFromAvroRows() error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("resourcesFromAvroRows(nil) = %+v, want nil", got)
+	}
+}
+
+func TestAvroStringField(t *testing.T) {
+	fields := map[string]interface{}{"table_name": "orders", "row_count": int64(5)}
+
+	if got := avroStringField(fields, "table_name"); got != "orders" {
+		t.Errorf("avroStringField(table_name) = %q, want %q", got, "orders")
+	}
+	if got := avroStringField(fields, "row_count"); got != "" {
+		t.Errorf("avroStringField(row_count) = %q, want empty string for non-string field", got)
+	}
+	if got := avroStringField(fields, "missing"); got != "" {
+		t.Errorf("avroStringField(missing) = %q, want empty string for missing field", got)
+	}
+}